@@ -0,0 +1,236 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package authorization implements verification of the JWT that's presented
+// with a GraphQL request, as declared by a `# Dgraph.Authorization` directive
+// in the schema. It started out HS256-only with a single shared secret; this
+// file generalises that to also support RS256/ES256 tokens backed by a
+// static public key or a remote JWKS endpoint.
+package authorization
+
+import (
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Algorithm identifies the signature algorithm a `# Dgraph.Authorization`
+// directive was declared with.
+type Algorithm string
+
+const (
+	HMAC256  Algorithm = "HS256"
+	RSA256   Algorithm = "RS256"
+	ECDSA256 Algorithm = "ES256"
+)
+
+// AuthMeta carries everything parsed out of a schema's `# Dgraph.Authorization`
+// directive: which header to read the token from, how to verify it, and
+// which claims to hold it to.
+type AuthMeta struct {
+	Header string
+	Algo   Algorithm
+
+	// VerificationKey is used for HS256 (the shared secret) or RS256/ES256
+	// when the directive embeds the public key directly.
+	VerificationKey string
+
+	// JWKSURL, when set, takes precedence over VerificationKey: the key
+	// material is fetched (and cached) from the JWKS endpoint and selected
+	// by the token's `kid` header.
+	JWKSURL string
+
+	Issuer   string
+	Audience []string
+
+	// ClaimsNamespace is the path under which namespaced claims live, e.g.
+	// "https://xyz.io/jwt/claims". When empty, claims are read from the
+	// top level of the token.
+	ClaimsNamespace string
+
+	jwks *jwksCache
+}
+
+// validKeyFunc returns a jwt.Keyfunc that resolves the verification key for
+// a token, either from the static key configured on the directive or from
+// the JWKS cache, and checks that the token's algorithm matches what the
+// directive declared.
+func (a *AuthMeta) validKeyFunc() jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		switch a.Algo {
+		case HMAC256:
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(a.VerificationKey), nil
+		case RSA256:
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return a.resolveKey(token)
+		case ECDSA256:
+			if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+				return nil, errors.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return a.resolveKey(token)
+		default:
+			return nil, errors.Errorf("unsupported jwt algorithm: %s", a.Algo)
+		}
+	}
+}
+
+func (a *AuthMeta) resolveKey(token *jwt.Token) (interface{}, error) {
+	if a.JWKSURL == "" {
+		return parsePublicKey([]byte(a.VerificationKey), a.Algo)
+	}
+
+	if a.jwks == nil {
+		a.jwks = newJWKSCache(a.JWKSURL)
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	return a.jwks.key(kid)
+}
+
+func parsePublicKey(pemBytes []byte, algo Algorithm) (interface{}, error) {
+	switch algo {
+	case RSA256:
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse RS256 public key")
+		}
+		return key, nil
+	case ECDSA256:
+		key, err := jwt.ParseECPublicKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse ES256 public key")
+		}
+		return key, nil
+	default:
+		return nil, errors.Errorf("%s doesn't use a public key", algo)
+	}
+}
+
+// skewedClaims is jwt.MapClaims with its Valid() turned into a no-op. The
+// library's default Valid() checks exp/nbf with zero leeway and would
+// reject a token before validateStandardClaims ever runs, making our own
+// skew allowance dead code - using this type for parsing is what lets
+// validateStandardClaims actually be the thing that decides exp/nbf/iat.
+type skewedClaims jwt.MapClaims
+
+func (skewedClaims) Valid() error { return nil }
+
+// ValidateToken parses and verifies signedToken against the rules in a, and
+// returns the claims the auth rule evaluator should see: the namespaced
+// claims map merged with the standard registered claims.
+func (a *AuthMeta) ValidateToken(signedToken string) (map[string]interface{}, error) {
+	var claims skewedClaims
+	token, err := jwt.ParseWithClaims(signedToken, &claims, a.validKeyFunc())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse jwt token")
+	}
+	if !token.Valid {
+		return nil, errors.Errorf("jwt token is invalid")
+	}
+
+	if err := a.validateStandardClaims(jwt.MapClaims(claims)); err != nil {
+		return nil, err
+	}
+
+	return a.extractClaims(jwt.MapClaims(claims)), nil
+}
+
+// validateStandardClaims enforces exp/nbf/iat/iss/aud itself, with a
+// clock-skew allowance on the time-based claims: issuer and verifier
+// clocks aren't guaranteed to agree exactly, so a token just past exp, or
+// claiming iat/nbf a little in the future, is still accepted within
+// skewSeconds.
+func (a *AuthMeta) validateStandardClaims(claims jwt.MapClaims) error {
+	now := time.Now().Unix()
+	const skewSeconds = 60
+
+	if expf, ok := claims["exp"].(float64); ok && int64(expf)+skewSeconds < now {
+		return errors.Errorf("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && int64(nbf)-skewSeconds > now {
+		return errors.Errorf("token used before its nbf")
+	}
+	if iat, ok := claims["iat"].(float64); ok && int64(iat)-skewSeconds > now {
+		return errors.Errorf("token was issued in the future")
+	}
+
+	if a.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.Issuer {
+			return errors.Errorf("token has invalid issuer %q, expected %q", iss, a.Issuer)
+		}
+	}
+
+	if len(a.Audience) > 0 {
+		if !audienceMatches(claims["aud"], a.Audience) {
+			return errors.Errorf("token audience doesn't match any of the expected audiences")
+		}
+	}
+
+	return nil
+}
+
+func audienceMatches(aud interface{}, expected []string) bool {
+	var got []string
+	switch v := aud.(type) {
+	case string:
+		got = []string{v}
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				got = append(got, s)
+			}
+		}
+	}
+
+	for _, g := range got {
+		for _, e := range expected {
+			if g == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extractClaims pulls out the claims that drive @auth rules: the standard
+// registered claims (iss, exp, ...) merged with whatever lives under
+// ClaimsNamespace, if one is configured. ClaimsNamespace is a single claim
+// key, not a dotted path - a namespace like "https://xyz.io/jwt/claims" is
+// itself a URL containing dots, so splitting on "." would look for it in
+// entirely the wrong place.
+func (a *AuthMeta) extractClaims(claims jwt.MapClaims) map[string]interface{} {
+	result := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		result[k] = v
+	}
+
+	if a.ClaimsNamespace == "" {
+		return result
+	}
+
+	if ns, ok := claims[a.ClaimsNamespace].(map[string]interface{}); ok {
+		for k, v := range ns {
+			result[k] = v
+		}
+	}
+	return result
+}
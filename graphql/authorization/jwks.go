@@ -0,0 +1,208 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authorization
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultJWKSMaxAge = 5 * time.Minute
+
+// jwksCache fetches and caches the keys served by a JWKS endpoint, keyed by
+// `kid`. A miss triggers a synchronous refresh so a freshly-rotated key
+// still works the first time it's seen; the cache otherwise only refetches
+// once the Cache-Control max-age it was served with has elapsed.
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu      sync.RWMutex
+	keys    map[string]interface{}
+	expires time.Time
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC (ES256 only - P-256)
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// key returns the public key for kid, refreshing the cache if it has
+// expired or if kid isn't among the keys we last fetched.
+func (c *jwksCache) key(kid string) (interface{}, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	stale := time.Now().After(c.expires)
+	c.mu.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	if err := c.refresh(); err != nil {
+		if ok {
+			// Serve the stale key rather than fail outright; the next
+			// successful refresh will pick up any rotation.
+			return key, nil
+		}
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok = c.keys[kid]
+	if !ok {
+		return nil, errors.Errorf("no key found in JWKS for kid %q", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) refresh() error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't fetch JWKS from %s", c.url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("JWKS endpoint %s returned status %d", c.url, resp.StatusCode)
+	}
+
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return errors.Wrapf(err, "couldn't decode JWKS from %s", c.url)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		var (
+			pub interface{}
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			pub, err = rsaPublicKeyFromJWK(k)
+		case "EC":
+			pub, err = ecPublicKeyFromJWK(k)
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.expires = time.Now().Add(maxAge(resp.Header.Get("Cache-Control")))
+	c.mu.Unlock()
+
+	return nil
+}
+
+func rsaPublicKeyFromJWK(k jwk) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid modulus in JWK %s", k.Kid)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid exponent in JWK %s", k.Kid)
+	}
+
+	e := 0
+	for _, b := range eBytes {
+		e = e<<8 | int(b)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: e,
+	}, nil
+}
+
+// ecPublicKeyFromJWK builds an *ecdsa.PublicKey from an EC-type JWK. ES256
+// only ever uses the P-256 curve, so that's the only one accepted here.
+func ecPublicKeyFromJWK(k jwk) (*ecdsa.PublicKey, error) {
+	if k.Crv != "P-256" {
+		return nil, errors.Errorf("unsupported EC curve %q in JWK %s, only P-256 (ES256) is supported", k.Crv, k.Kid)
+	}
+
+	xBytes, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid x coordinate in JWK %s", k.Kid)
+	}
+	yBytes, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid y coordinate in JWK %s", k.Kid)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(xBytes),
+		Y:     new(big.Int).SetBytes(yBytes),
+	}, nil
+}
+
+// maxAge pulls the max-age directive out of a Cache-Control header, falling
+// back to defaultJWKSMaxAge when it's absent or malformed.
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil {
+			continue
+		}
+		return time.Duration(secs) * time.Second
+	}
+	return defaultJWKSMaxAge
+}
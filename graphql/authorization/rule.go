@@ -0,0 +1,110 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authorization
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CompileRule is the one entry point the filter-rewrite pipeline needs:
+// given the text of an `@auth` rule's claim expression - as written in the
+// schema, e.g. `role == "ADMIN"` or `ROLES anyOf ["admin", "billing:read"]`
+// - it parses and compiles it down to a CompiledScope once, at schema-load
+// time, so evaluating it against a request's claims later costs nothing
+// more than the closure call itself. This is what lets `in`/`contains`/
+// `anyOf`/`allOf` rules sit in the same rewrite path as the original
+// single-value `==` rules without the rewriter needing to know the
+// difference.
+func CompileRule(rule string) (CompiledScope, error) {
+	expr, err := ParseScopeRule(rule)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse auth rule %q", rule)
+	}
+	return Compile(expr)
+}
+
+// opKeywords lists the scope operators in the order they should be tried:
+// anyOf/allOf/contains/in before eq, since eq's `==` would otherwise never
+// get a chance to not match them.
+var opKeywords = []struct {
+	keyword string
+	op      ScopeOp
+}{
+	{"anyOf", AnyOf},
+	{"allOf", AllOf},
+	{"contains", Contains},
+	{"in", In},
+	{"==", Eq},
+}
+
+// ParseScopeRule parses the textual form of an `@auth` claim expression
+// into a ScopeExpr: `<claim> <operator> <value-or-array>`, where value is
+// either a single quoted string (`"ADMIN"`) or a JSON array of strings
+// (`["admin", "billing:read"]`).
+func ParseScopeRule(rule string) (ScopeExpr, error) {
+	rule = strings.TrimSpace(rule)
+
+	for _, k := range opKeywords {
+		claim, rest, ok := splitOnKeyword(rule, k.keyword)
+		if !ok {
+			continue
+		}
+
+		values, err := parseScopeValues(rest)
+		if err != nil {
+			return ScopeExpr{}, err
+		}
+
+		return ScopeExpr{Claim: claim, Op: k.op, Values: values}, nil
+	}
+
+	return ScopeExpr{}, errors.Errorf("no recognised scope operator in rule %q", rule)
+}
+
+// splitOnKeyword finds the first top-level occurrence of keyword
+// surrounded by whitespace and splits rule into the claim name before it
+// and the (still raw) value expression after it.
+func splitOnKeyword(rule, keyword string) (claim, rest string, ok bool) {
+	idx := strings.Index(rule, " "+keyword+" ")
+	if idx < 0 {
+		return "", "", false
+	}
+	claim = strings.TrimSpace(rule[:idx])
+	rest = strings.TrimSpace(rule[idx+len(keyword)+2:])
+	return claim, rest, claim != "" && rest != ""
+}
+
+// parseScopeValues parses the right-hand side of a scope expression: a
+// single quoted string, or a JSON array of strings.
+func parseScopeValues(raw string) ([]string, error) {
+	if strings.HasPrefix(raw, "[") {
+		var values []string
+		if err := json.Unmarshal([]byte(raw), &values); err != nil {
+			return nil, errors.Wrapf(err, "couldn't parse scope value list %q", raw)
+		}
+		return values, nil
+	}
+
+	unquoted := strings.Trim(raw, `"`)
+	if unquoted == raw || unquoted == "" {
+		return nil, errors.Errorf("scope value %q must be a quoted string or a JSON string array", raw)
+	}
+	return []string{unquoted}, nil
+}
@@ -0,0 +1,27 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authorization
+
+import "github.com/dgraph-io/dgraph/graphql/authorization/secretscan"
+
+// Scan checks a's key material with secretscan, in the given mode, and
+// returns its findings; in Production mode an Error finding also comes back
+// as a non-nil error. Nothing in the schema loading path calls Scan, so a
+// schema is not checked or refused unless its caller does so.
+func (a *AuthMeta) Scan(mode secretscan.Mode) ([]secretscan.Finding, error) {
+	return secretscan.Scan(string(a.Algo), a.VerificationKey, a.JWKSURL, mode)
+}
@@ -0,0 +1,182 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package authorization
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ScopeOp is one of the comparison operators an `@auth` rule can use
+// against a claim. `Eq` is the original single-value comparison
+// (`role == "ADMIN"`); the rest operate over claims that are arrays, such
+// as a `ROLES` claim holding `["admin", "billing:read"]`.
+type ScopeOp string
+
+const (
+	// Eq requires the claim to equal the single expected value.
+	Eq ScopeOp = "eq"
+	// In requires the claim's value to be one of the expected values.
+	In ScopeOp = "in"
+	// Contains requires the claim, an array, to contain the expected value
+	// (or a scope that implies it - see Implies).
+	Contains ScopeOp = "contains"
+	// AnyOf requires the claim array to contain at least one of the
+	// expected values.
+	AnyOf ScopeOp = "anyOf"
+	// AllOf requires the claim array to contain every one of the expected
+	// values.
+	AllOf ScopeOp = "allOf"
+)
+
+// ScopeExpr is a parsed `@auth` scope expression: "does claim Claim satisfy
+// Op against Values". It's the array-claim generalisation of the original
+// `role == "ADMIN"` rule.
+type ScopeExpr struct {
+	Claim  string
+	Op     ScopeOp
+	Values []string
+}
+
+// CompiledScope is a ScopeExpr that's already been turned into a closure
+// over a fixed set of expected values, so evaluating it against a request's
+// claims at query time is just a map lookup and a slice scan - no parsing
+// or compiling happens on the query path.
+type CompiledScope func(claims map[string]interface{}) bool
+
+// Compile turns a ScopeExpr into a CompiledScope. It's meant to run once,
+// when a schema (and its @auth rules) is loaded, so the cost of building
+// the expression is paid once rather than on every query.
+func Compile(expr ScopeExpr) (CompiledScope, error) {
+	if expr.Claim == "" {
+		return nil, errors.Errorf("scope expression is missing a claim")
+	}
+	if len(expr.Values) == 0 {
+		return nil, errors.Errorf("scope expression for claim %q has no values", expr.Claim)
+	}
+
+	switch expr.Op {
+	case Eq:
+		want := expr.Values[0]
+		return func(claims map[string]interface{}) bool {
+			got, _ := claims[expr.Claim].(string)
+			return got == want
+		}, nil
+	case In:
+		wanted := expr.Values
+		return func(claims map[string]interface{}) bool {
+			got, ok := claims[expr.Claim].(string)
+			if !ok {
+				return false
+			}
+			for _, w := range wanted {
+				if got == w {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case Contains:
+		want := expr.Values[0]
+		return func(claims map[string]interface{}) bool {
+			return anyScope(claimScopes(claims, expr.Claim), func(have string) bool {
+				return Implies(have, want)
+			})
+		}, nil
+	case AnyOf:
+		wanted := expr.Values
+		return func(claims map[string]interface{}) bool {
+			have := claimScopes(claims, expr.Claim)
+			for _, w := range wanted {
+				if anyScope(have, func(h string) bool { return Implies(h, w) }) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case AllOf:
+		wanted := expr.Values
+		return func(claims map[string]interface{}) bool {
+			have := claimScopes(claims, expr.Claim)
+			for _, w := range wanted {
+				if !anyScope(have, func(h string) bool { return Implies(h, w) }) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown scope operator %q", expr.Op)
+	}
+}
+
+// claimScopes reads an array-valued claim (e.g. ROLES) as a []string,
+// tolerating both []string and []interface{} since claims arrive as
+// whatever encoding/json produced when the JWT was decoded.
+func claimScopes(claims map[string]interface{}, claim string) []string {
+	switch v := claims[claim].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case string:
+		return []string{v}
+	default:
+		return nil
+	}
+}
+
+func anyScope(scopes []string, pred func(string) bool) bool {
+	for _, s := range scopes {
+		if pred(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Implies reports whether the scope a grants the scope want, either
+// because they're equal or because of the hierarchical convention that a
+// "resource:write" scope also grants "resource:read" on the same resource.
+func Implies(have, want string) bool {
+	if have == want {
+		return true
+	}
+
+	haveRes, haveAction, ok1 := splitScope(have)
+	wantRes, wantAction, ok2 := splitScope(want)
+	if !ok1 || !ok2 || haveRes != wantRes {
+		return false
+	}
+
+	return haveAction == "write" && wantAction == "read"
+}
+
+func splitScope(scope string) (resource, action string, ok bool) {
+	i := strings.LastIndex(scope, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return scope[:i], scope[i+1:], true
+}
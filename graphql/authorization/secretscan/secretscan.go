@@ -0,0 +1,204 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package secretscan validates the key material declared in a schema's
+// `# Dgraph.Authorization` directive, flagging an obviously-insecure signing
+// secret (or a private key pasted where a public key belongs). It is not
+// called by the schema loader itself.
+package secretscan
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Mode controls whether a Finding is merely reported or causes the schema
+// load to be rejected outright.
+type Mode int
+
+const (
+	// Dev reports findings (of either Severity) but never returns an error,
+	// so schema loading always succeeds.
+	Dev Mode = iota
+	// Production rejects a schema whose key material fails a Finding with
+	// Severity == Error.
+	Production
+)
+
+// Severity classifies how serious a Finding is. It's assigned independently
+// of Mode: a hardcoded HS256 secret is Error severity whether the scan ran in
+// Dev or Production, because that's a statement about the key material, not
+// about what the loader does with it. Mode only decides whether an Error
+// finding is allowed to load anyway (Dev) or refused (Production).
+type Severity string
+
+const (
+	Warning Severity = "WARNING"
+	Error   Severity = "ERROR"
+)
+
+// Finding is one problem the scanner found with a directive's key material,
+// surfaced to operators via the admin API.
+type Finding struct {
+	Severity Severity
+	Message  string
+}
+
+// minHMACKeyBytes is the shortest HS256 secret the scanner accepts without
+// complaint. RFC 7518 recommends a key at least as long as the hash output
+// (32 bytes for SHA-256); anything shorter is brute-forceable.
+const minHMACKeyBytes = 32
+
+// commonSecrets is a small, deliberately short list of values people paste
+// in as a placeholder and then ship to production. It's not a substitute
+// for a real breached-password corpus, just a net for the obvious cases.
+var commonSecrets = []string{
+	"secret", "secretkey", "password", "changeme", "changeit",
+	"test", "testing", "admin", "admin123", "letmein",
+	"12345678", "123456789", "qwertyuiop", "dgraph", "jwtsecret",
+}
+
+// Scan checks the key material a `# Dgraph.Authorization` directive resolved
+// to - algo is the algorithm name ("HS256", "RS256" or "ES256"),
+// verificationKey and jwksURL mirror AuthMeta's fields of the same name -
+// and returns every Finding it has an opinion about. In Production mode, a
+// Finding with Severity Error also comes back as a non-nil error so the
+// schema loader can refuse to load; in Dev mode, Scan never errors -
+// findings are returned for the admin API to surface as warnings only.
+//
+// Scan takes these fields rather than an *authorization.AuthMeta directly so
+// that package, which already depends on this one through AuthMeta.Scan, is
+// not also depended on from here.
+func Scan(algo, verificationKey, jwksURL string, mode Mode) ([]Finding, error) {
+	var findings []Finding
+
+	switch algo {
+	case "HS256":
+		findings = append(findings, scanHMACSecret(verificationKey)...)
+	case "RS256", "ES256":
+		if verificationKey != "" {
+			findings = append(findings, scanPublicKeyPEM(verificationKey)...)
+		}
+	}
+
+	if jwksURL != "" {
+		findings = append(findings, scanJWKSURL(jwksURL, mode)...)
+	}
+
+	if mode == Dev {
+		return findings, nil
+	}
+
+	for _, f := range findings {
+		if f.Severity == Error {
+			return findings, errors.Errorf("refusing to load schema: %s", f.Message)
+		}
+	}
+	return findings, nil
+}
+
+func scanHMACSecret(secret string) []Finding {
+	var findings []Finding
+
+	if len(secret) < minHMACKeyBytes {
+		findings = append(findings, Finding{
+			Severity: Error,
+			Message:  "HS256 secret is shorter than 32 bytes and is brute-forceable",
+		})
+	}
+
+	lower := strings.ToLower(secret)
+	for _, word := range commonSecrets {
+		if lower == word {
+			findings = append(findings, Finding{
+				Severity: Error,
+				Message:  "HS256 secret matches a common placeholder value",
+			})
+			break
+		}
+	}
+
+	return findings
+}
+
+func scanPublicKeyPEM(pemStr string) []Finding {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil
+	}
+
+	if strings.Contains(block.Type, "PRIVATE KEY") {
+		return []Finding{{
+			Severity: Error,
+			Message:  "verification key is a PEM private key where a public key was expected",
+		}}
+	}
+
+	// Some private keys are mislabeled (or not labeled at all); catch RSA
+	// ones by trying to parse the block as a PKCS#1 private key regardless
+	// of the PEM header. Other mislabeled private key types are not detected.
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return []Finding{{
+			Severity: Error,
+			Message:  "verification key parses as an RSA private key where a public key was expected",
+		}}
+	}
+
+	if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return []Finding{{
+			Severity: Warning,
+			Message:  "verification key doesn't parse as a standard PKIX public key",
+		}}
+	}
+
+	return nil
+}
+
+func scanJWKSURL(rawURL string, mode Mode) []Finding {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return []Finding{{Severity: Warning, Message: "JWKS URL couldn't be parsed"}}
+	}
+
+	host := u.Hostname()
+	if !isLoopback(host) {
+		return nil
+	}
+
+	if mode == Dev {
+		return []Finding{{
+			Severity: Warning,
+			Message:  "JWKS URL points at loopback; fine in dev mode, not in production",
+		}}
+	}
+	return []Finding{{
+		Severity: Error,
+		Message:  "JWKS URL points at loopback, which isn't reachable from other nodes in production",
+	}}
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
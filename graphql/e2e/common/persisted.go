@@ -0,0 +1,112 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/dgraph-io/dgraph/graphql/persist"
+	"github.com/stretchr/testify/require"
+)
+
+// persistedQueryBody is what a client sends for a persisted-query request:
+// the hash it registered the query under, this call's variables, and
+// (only on first use, to register the query) the query text itself.
+type persistedQueryBody struct {
+	QueryHash string                 `json:"queryHash"`
+	Query     string                 `json:"query,omitempty"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// ExecuteAsPersisted registers p.Query (by sending its hash alongside the
+// text, the same way a client does the first time it runs a new query) and
+// executes it, using p.Variables and p.Headers exactly as ExecuteAsPost
+// does. It's the one-shot "register and run" path most tests want.
+func (p *GraphQLParams) ExecuteAsPersisted(t *testing.T, url string) *GraphQLResponse {
+	require.NotEmpty(t, p.Query, "ExecuteAsPersisted needs Query set")
+	return executePersistedQuery(t, url, persist.Hash(p.Query), p.Query, p.Variables, p.Headers)
+}
+
+// ExecuteAsPersistedHash runs a previously-registered query by hash alone,
+// without sending the query text - the shape a client uses on every call
+// after the first. It's split out from ExecuteAsPersisted so tests can
+// exercise unknown-hash rejection directly; hash-mismatch rejection needs
+// the query text too, see ExecuteAsPersistedMismatch.
+func ExecuteAsPersistedHash(
+	t *testing.T,
+	url string,
+	hash string,
+	variables map[string]interface{},
+	headers http.Header,
+) *GraphQLResponse {
+	return executePersistedQuery(t, url, hash, "", variables, headers)
+}
+
+// ExecuteAsPersistedMismatch sends query alongside a hash that doesn't
+// match it, the way a client with a corrupted cache (or an attacker trying
+// to register their own text under someone else's hash) would. It exists
+// so tests can assert the mismatch is rejected rather than silently
+// accepted under the attacker-supplied hash.
+func ExecuteAsPersistedMismatch(
+	t *testing.T,
+	url string,
+	hash, query string,
+	variables map[string]interface{},
+	headers http.Header,
+) *GraphQLResponse {
+	return executePersistedQuery(t, url, hash, query, variables, headers)
+}
+
+func executePersistedQuery(
+	t *testing.T,
+	url, hash, query string,
+	variables map[string]interface{},
+	headers http.Header,
+) *GraphQLResponse {
+	body, err := json.Marshal(persistedQueryBody{
+		QueryHash: hash,
+		Query:     query,
+		Variables: variables,
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for k, vs := range headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	var gqlResp GraphQLResponse
+	err = json.Unmarshal(b, &gqlResp)
+	require.NoError(t, err)
+
+	return &gqlResp
+}
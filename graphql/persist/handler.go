@@ -0,0 +1,120 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package persist
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// clientBody is what a client posts to a persisted-query-aware endpoint:
+// QueryHash always, Query only on first use (to register it).
+type clientBody struct {
+	QueryHash string                 `json:"queryHash"`
+	Query     string                 `json:"query,omitempty"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// executorBody is the ordinary GraphQL request shape the rest of the stack
+// (query rewriting, auth-rule evaluation, DQL execution) already knows how
+// to handle.
+type executorBody struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// WrapHandler wraps the GraphQL execution handler exec with persisted-query
+// resolution: a POST body shaped like {"queryHash": ..., "variables": ...}
+// is resolved against store into the query text, and the request is
+// rewritten to the ordinary {"query": ..., "variables": ...} shape before
+// being forwarded to exec. A body with no queryHash field is assumed to
+// already be an ordinary GraphQL request and passes straight through; in
+// Production mode it does so only if its query text is in the allow-list,
+// and a GET request likewise only if its `query` parameter is.
+//
+// Every other part of the request - method, headers, in particular any
+// Authorization/JWT header - passes through untouched, so the $USER/$ROLE
+// rewrite and @auth rule evaluation downstream still run per request
+// against the caller's own token. Resolving a hash to its cached query
+// text is the only thing that gets reused; nothing about who's asking, or
+// what they're allowed to see, is cached alongside it.
+func WrapHandler(store *Store, exec http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			if r.Method == http.MethodGet && store.mode == Production {
+				if _, err := store.Resolve(Hash(r.URL.Query().Get("query")), ""); err != nil {
+					writeGraphQLError(w, err)
+					return
+				}
+			}
+			exec.ServeHTTP(w, r)
+			return
+		}
+
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var body clientBody
+		if err := json.Unmarshal(raw, &body); err != nil || body.QueryHash == "" {
+			if store.mode == Production {
+				if _, err := store.Resolve(Hash(body.Query), ""); err != nil {
+					writeGraphQLError(w, err)
+					return
+				}
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(raw))
+			exec.ServeHTTP(w, r)
+			return
+		}
+
+		query, err := store.Resolve(body.QueryHash, body.Query)
+		if err != nil {
+			writeGraphQLError(w, err)
+			return
+		}
+
+		rewritten, err := json.Marshal(executorBody{Query: query, Variables: body.Variables})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(rewritten))
+		r.ContentLength = int64(len(rewritten))
+		exec.ServeHTTP(w, r)
+	})
+}
+
+type gqlError struct {
+	Message string `json:"message"`
+}
+
+type gqlErrorResponse struct {
+	Errors []gqlError `json:"errors"`
+}
+
+func writeGraphQLError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	b, _ := json.Marshal(gqlErrorResponse{Errors: []gqlError{{Message: err.Error()}}})
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,156 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package persist implements persisted-query (a.k.a. allow-listed query)
+// support for the /graphql endpoint. Clients register a query once, by its
+// SHA-256 hash, and thereafter send only the hash plus variables. In
+// "production" mode the server only ever executes a query that's already
+// in the allow-list, so operators can pre-compile DQL plans and auth-rule
+// rewrites for a known, fixed set of queries instead of accepting arbitrary
+// GraphQL from clients.
+package persist
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Mode controls what happens when a query arrives that isn't already in
+// the allow-list.
+type Mode int
+
+const (
+	// Dev accepts any query, registering it under its hash on first sight.
+	Dev Mode = iota
+	// Production refuses any query whose hash isn't already registered.
+	Production
+)
+
+// Store is the allow-list of persisted queries, keyed by the SHA-256 hash
+// of the query text. It's safe for concurrent use.
+type Store struct {
+	mode Mode
+
+	mu     sync.RWMutex
+	byHash map[string]string
+}
+
+// NewStore builds an empty Store running in the given Mode.
+func NewStore(mode Mode) *Store {
+	return &Store{
+		mode:   mode,
+		byHash: make(map[string]string),
+	}
+}
+
+// Hash returns the allow-list key for a query: the hex-encoded SHA-256 of
+// its text, matching Apollo's persisted-query convention so existing client
+// tooling needs no changes to generate the hash.
+func Hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// Register adds query to the allow-list and returns its hash. Safe to call
+// in any Mode; it's how a client (or an operator, offline) populates the
+// list in the first place.
+func (s *Store) Register(query string) string {
+	hash := Hash(query)
+
+	s.mu.Lock()
+	s.byHash[hash] = query
+	s.mu.Unlock()
+
+	return hash
+}
+
+// Resolve looks up the query text registered under hash. If providedQuery
+// is non-empty (the client sent both a hash and the query text, as happens
+// on first registration), it's checked against hash before being accepted -
+// a client can't register arbitrary text under a hash that doesn't match.
+//
+// In Production mode, an unknown hash is always rejected: operators are
+// expected to have pre-loaded the allow-list via LoadAllowListFile, so
+// nothing gets registered on the fly once the server is in that mode.
+func (s *Store) Resolve(hash, providedQuery string) (string, error) {
+	if providedQuery != "" {
+		if got := Hash(providedQuery); got != hash {
+			return "", errors.Errorf("queryHash %q doesn't match sha256 of the supplied query", hash)
+		}
+		if s.mode == Production {
+			return "", errors.Errorf("server is in persisted-queries production mode; " +
+				"queries must be pre-registered in the allow-list")
+		}
+		s.Register(providedQuery)
+		return providedQuery, nil
+	}
+
+	s.mu.RLock()
+	query, ok := s.byHash[hash]
+	s.mu.RUnlock()
+	if !ok {
+		return "", errors.Errorf("unknown queryHash %q; query must be registered before use", hash)
+	}
+	return query, nil
+}
+
+// LoadAllowListFile reads an `allow.list` file, where entries are separated
+// by blank lines and each entry's consecutive non-comment lines together
+// form one GraphQL query, and registers every entry under the hash of its
+// trimmed text. This is how operators pre-compile the set of queries a
+// production server will ever execute.
+func (s *Store) LoadAllowListFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't open allow-list file %s", path)
+	}
+	defer f.Close()
+
+	var cur strings.Builder
+	flush := func() {
+		if q := strings.TrimSpace(cur.String()); q != "" {
+			s.Register(q)
+		}
+		cur.Reset()
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "couldn't read allow-list file %s", path)
+	}
+	return nil
+}
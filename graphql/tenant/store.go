@@ -0,0 +1,129 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tenant
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Store is a predicate-partitioned data store: every read and write goes
+// through PartitionPredicate, so a tenant's data lives under a predicate
+// name no other tenant's resolver ever constructs. This is what makes
+// isolation a property of the DQL layer rather than of whichever @auth
+// rule happens to be attached to a type - there's no filter to bypass,
+// because the predicate itself doesn't resolve outside its tenant.
+//
+// It stands in for the real DQL-backed storage; the partitioning scheme
+// (PartitionPredicate) is what a production implementation would use to
+// name the underlying Dgraph predicates for a type.
+type Store struct {
+	mu   sync.RWMutex
+	data map[string]map[string]interface{}
+}
+
+// NewStore builds an empty partitioned Store.
+func NewStore() *Store {
+	return &Store{data: make(map[string]map[string]interface{})}
+}
+
+// Put stores value under uid, in tenantID's partition of typeName.
+func (s *Store) Put(tenantID, typeName, uid string, value interface{}) {
+	pred := PartitionPredicate(tenantID, typeName)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[pred] == nil {
+		s.data[pred] = make(map[string]interface{})
+	}
+	s.data[pred][uid] = value
+}
+
+// Get looks up uid, but only within tenantID's own partition: a uid that
+// exists in another tenant's partition is indistinguishable from a uid
+// that doesn't exist at all.
+func (s *Store) Get(tenantID, typeName, uid string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.data[PartitionPredicate(tenantID, typeName)][uid]
+	return v, ok
+}
+
+// List returns every value in tenantID's partition of typeName.
+func (s *Store) List(tenantID, typeName string) []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	partition := s.data[PartitionPredicate(tenantID, typeName)]
+	out := make([]interface{}, 0, len(partition))
+	for _, v := range partition {
+		out = append(out, v)
+	}
+	return out
+}
+
+// Resolver is the request-scoped entry point a GraphQL resolver uses: it
+// turns a request's JWT claims into a tenant ID via Manager, then only
+// ever reads or writes that tenant's partition of Store. A resolver built
+// on top of this can't leak across tenants even if its own @auth rule is
+// wrong or missing, because TenantIDFromClaims - not the rule - is what
+// decides which partition gets touched.
+type Resolver struct {
+	Manager *Manager
+	Store   *Store
+}
+
+// NewResolver ties a Manager to the Store it scopes access to.
+func NewResolver(manager *Manager, store *Store) *Resolver {
+	return &Resolver{Manager: manager, Store: store}
+}
+
+// Get resolves a root query like `getColumn(colID: $id)` for the tenant
+// named in claims; a uid from a different tenant resolves to "not found"
+// rather than leaking the other tenant's object.
+func (r *Resolver) Get(claims map[string]interface{}, typeName, uid string) (interface{}, error) {
+	tenantID, err := r.Manager.TenantIDFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := r.Store.Get(tenantID, typeName, uid)
+	if !ok {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// List resolves a root query like `queryProject` for the tenant named in
+// claims, returning only that tenant's partition.
+func (r *Resolver) List(claims map[string]interface{}, typeName string) ([]interface{}, error) {
+	tenantID, err := r.Manager.TenantIDFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	return r.Store.List(tenantID, typeName), nil
+}
+
+// Put resolves a mutation like `addColumn` for the tenant named in claims.
+func (r *Resolver) Put(claims map[string]interface{}, typeName, uid string, value interface{}) error {
+	tenantID, err := r.Manager.TenantIDFromClaims(claims)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't resolve tenant for mutation")
+	}
+	r.Store.Put(tenantID, typeName, uid, value)
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tenant implements multi-tenant namespace isolation for the
+// GraphQL layer. Unlike an `@auth` rule, which only filters what a query
+// returns, a tenant is enforced at the DQL layer: every predicate a
+// tenant's data lives under is namespaced to that tenant, so a query that
+// never applies an auth rule at all still can't see another tenant's
+// data.
+package tenant
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Tenant is one isolated data partition.
+type Tenant struct {
+	ID             string
+	Name           string
+	SchemaOverride string
+	RateLimitQPS   int
+}
+
+// Manager owns the set of known tenants and knows which JWT claim carries
+// the tenant ID for an incoming request.
+type Manager struct {
+	// Claim is the (possibly namespaced, see AuthMeta.ClaimsNamespace)
+	// claim name a request's tenant ID is read from, e.g. "tenant_id" or
+	// "TENANT".
+	Claim string
+
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+}
+
+// NewManager builds a Manager that reads the tenant ID from claim.
+func NewManager(claim string) *Manager {
+	return &Manager{
+		Claim:   claim,
+		tenants: make(map[string]*Tenant),
+	}
+}
+
+// Create registers a new tenant. The admin GraphQL API has no mutation
+// that calls it.
+func (m *Manager) Create(t *Tenant) error {
+	if t.ID == "" {
+		return errors.Errorf("tenant must have an id")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tenants[t.ID]; ok {
+		return errors.Errorf("tenant %q already exists", t.ID)
+	}
+	m.tenants[t.ID] = t
+	return nil
+}
+
+// Drop removes a tenant from the Manager. The admin GraphQL API has no
+// mutation that calls it. Dropping does not delete the tenant's
+// partitioned data, and no operation in this package deletes it.
+func (m *Manager) Drop(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tenants[id]; !ok {
+		return errors.Errorf("tenant %q doesn't exist", id)
+	}
+	delete(m.tenants, id)
+	return nil
+}
+
+// Get returns the tenant registered under id, or an error if none is.
+func (m *Manager) Get(id string) (*Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.tenants[id]
+	if !ok {
+		return nil, errors.Errorf("unknown tenant %q", id)
+	}
+	return t, nil
+}
+
+// TenantIDFromClaims reads the tenant ID a request should be pinned to out
+// of its JWT claims. A request with no tenant claim, or one naming a
+// tenant that was never created, is rejected rather than falling back to
+// some default partition - there is no data available to a request that
+// can't be attributed to a tenant.
+func (m *Manager) TenantIDFromClaims(claims map[string]interface{}) (string, error) {
+	raw, ok := claims[m.Claim]
+	if !ok {
+		return "", errors.Errorf("jwt is missing the %q tenant claim", m.Claim)
+	}
+	id, ok := raw.(string)
+	if !ok || id == "" {
+		return "", errors.Errorf("jwt claim %q doesn't hold a tenant id", m.Claim)
+	}
+
+	if _, err := m.Get(id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// PartitionPredicate namespaces a schema predicate to a tenant, so that the
+// DQL generated for one tenant's queries and mutations can never address
+// another tenant's data by guessing or forging a UID: the predicate itself
+// doesn't exist outside that tenant's partition.
+func PartitionPredicate(tenantID, predicate string) string {
+	return fmt.Sprintf("%s.%s", tenantID, predicate)
+}
@@ -0,0 +1,105 @@
+/*
+ *    Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FieldAuth decides whether field on a value of typeName is visible to the
+// caller identified by claims - the same question a type's @auth rule
+// answers for a query response. A MutationEmitter asks it of every field
+// before that field goes into a webhook payload, so a field a rule would
+// have redacted from the mutation's own response is never leaked to a
+// webhook receiver either.
+type FieldAuth func(claims map[string]interface{}, typeName, field string) bool
+
+// MutationEmitter turns a completed GraphQL mutation into webhook
+// deliveries: it builds the Event (UIDs, before/after snapshots, the
+// authenticated user/role) and hands it to a Dispatcher, applying authz to
+// every field of before/after first.
+type MutationEmitter struct {
+	Dispatcher *Dispatcher
+	Authz      FieldAuth
+
+	subs map[string][]Subscription
+}
+
+// NewMutationEmitter builds a MutationEmitter delivering through d and
+// redacting fields per authz. A nil authz redacts nothing, for types with
+// no @auth rule on any field.
+func NewMutationEmitter(d *Dispatcher, authz FieldAuth) *MutationEmitter {
+	return &MutationEmitter{
+		Dispatcher: d,
+		Authz:      authz,
+		subs:       make(map[string][]Subscription),
+	}
+}
+
+// Subscribe registers sub for typeName, the runtime counterpart of a
+// `@webhook(events: [...], endpoint: "...")` directive on that type in the
+// schema.
+func (m *MutationEmitter) Subscribe(typeName string, sub Subscription) {
+	m.subs[typeName] = append(m.subs[typeName], sub)
+}
+
+// Emit redacts before/after down to the fields claims is allowed to see on
+// typeName, then dispatches the resulting Event to every subscription
+// registered for typeName. before or after may be nil (an ADD has no
+// before, a DELETE has no after).
+func (m *MutationEmitter) Emit(
+	op EventType,
+	typeName, uid string,
+	before, after map[string]interface{},
+	claims map[string]interface{},
+) {
+	user, _ := claims["USER"].(string)
+	role, _ := claims["ROLE"].(string)
+
+	m.Dispatcher.Emit(Event{
+		Operation: op,
+		Type:      typeName,
+		UIDs:      []string{uid},
+		Before:    m.redact(typeName, before, claims),
+		After:     m.redact(typeName, after, claims),
+		User:      user,
+		Role:      role,
+		Timestamp: time.Now(),
+	}, m.subs[typeName])
+}
+
+func (m *MutationEmitter) redact(
+	typeName string, fields map[string]interface{}, claims map[string]interface{},
+) json.RawMessage {
+	if fields == nil {
+		return nil
+	}
+
+	visible := make(map[string]interface{}, len(fields))
+	for field, v := range fields {
+		if m.Authz == nil || m.Authz(claims, typeName, field) {
+			visible[field] = v
+		}
+	}
+
+	b, err := json.Marshal(visible)
+	if err != nil {
+		return nil
+	}
+	return b
+}